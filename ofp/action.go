@@ -0,0 +1,138 @@
+package ofp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/netrack/openflow/internal/encoding"
+)
+
+const (
+	// Output packet to a switch port.
+	ActionTypeOutput ActionType = iota
+
+	// Experimenter action.
+	ActionTypeExperimenter ActionType = 0xffff
+)
+
+// ActionType represents a type of the datapath action.
+type ActionType uint16
+
+var actionMap = map[ActionType]encoding.ReaderMaker{
+	ActionTypeOutput: encoding.ReaderMakerOf(ActionOutput{}),
+}
+
+// Action header that is common to all actions. The length includes the
+// header and any padding used to make the action 64-bit aligned.
+type actionhdr struct {
+	// Type is an action type.
+	Type ActionType
+
+	// Length of this structure in bytes.
+	Len uint16
+}
+
+type Action interface {
+	encoding.ReadWriter
+
+	// Type returns the type of the action.
+	Type() ActionType
+}
+
+// Actions represents a bundle of datapath actions, as embedded into
+// InstructionApplyActions and InstructionWriteActions.
+type Actions []Action
+
+// WriteTo implements io.WriterTo interface.
+func (a *Actions) WriteTo(w io.Writer) (n int64, err error) {
+	var buf bytes.Buffer
+
+	for _, act := range *a {
+		_, err = act.WriteTo(&buf)
+		if err != nil {
+			return
+		}
+	}
+
+	return encoding.WriteTo(w, buf.Bytes())
+}
+
+func (a *Actions) ReadFrom(r io.Reader) (n int64, err error) {
+	var actType ActionType
+
+	rm := func() (io.ReaderFrom, error) {
+		if rm, ok := actionMap[actType]; ok {
+			rd, err := rm.MakeReader()
+			*a = append(*a, rd.(Action))
+			return rd, err
+		}
+
+		format := "ofp: unknown action type: '%x'"
+		return nil, fmt.Errorf(format, actType)
+	}
+
+	return encoding.ScanFrom(r, &actType,
+		encoding.ReaderMakerFunc(rm))
+}
+
+// bytes serializes the actions, so instructions embedding them can
+// learn their encoded length before writing their own header.
+func (a Actions) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := (&a).WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PortNo identifies a switch port, either physical, logical, or one of
+// the reserved ports below.
+type PortNo uint32
+
+const (
+	// PortFlood outputs the packet on all ports except the input port
+	// and ports that are configured not to flood.
+	PortFlood PortNo = 0xfffffffb + iota
+
+	// PortAll outputs the packet on all ports except the input port.
+	PortAll
+
+	// PortController sends the packet to the controller.
+	PortController
+
+	// PortLocal represents the local openflow "port".
+	PortLocal
+
+	// PortAny is used in some requests when no port is specified, i.e.
+	// wildcarded.
+	PortAny
+)
+
+// ActionOutput outputs the packet to the switch port.
+//
+// When Port is PortController, MaxLen indicates the maximum number of
+// bytes of the packet to send to the controller; a value of zero means
+// no bytes should be sent.
+type ActionOutput struct {
+	// Port is the port to output the packet to.
+	Port PortNo
+
+	// MaxLen limits the number of bytes sent to the controller, when
+	// Port is PortController.
+	MaxLen uint16
+}
+
+// Type implements Action interface and returns the type of the action.
+func (a *ActionOutput) Type() ActionType {
+	return ActionTypeOutput
+}
+
+// WriteTo implements WriterTo interface.
+func (a *ActionOutput) WriteTo(w io.Writer) (int64, error) {
+	return encoding.WriteTo(w, actionhdr{a.Type(), 16}, a.Port, a.MaxLen, pad6{})
+}
+
+func (a *ActionOutput) ReadFrom(r io.Reader) (int64, error) {
+	return encoding.ReadFrom(r, &actionhdr{}, &a.Port, &a.MaxLen, &defaultPad6)
+}
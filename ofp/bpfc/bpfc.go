@@ -0,0 +1,249 @@
+// Package bpfc compiles classic BPF packet filter programs, as used by
+// golang.org/x/net/bpf and libpcap-style filter expressions, into
+// OpenFlow flow-table entries.
+//
+// Only the subset of BPF expressible as matches against a handful of
+// well-known header fields of an untagged Ethernet II frame carrying an
+// IPv4 datagram is supported: EtherType, IP protocol, and TCP/UDP
+// source/destination ports -- the fields addressed by filters such as
+// "ip proto tcp" or "tcp dst port 80". Compile walks the program as the
+// decision tree it represents and emits one flow entry per path that
+// ends in a RetConstant, so a RetConstant reached along several paths
+// yields several entries. Anything outside of that subset -- arithmetic on
+// registers, scratch memory, loads at offsets the compiler does not
+// recognize -- is rejected with an error rather than silently ignored,
+// since a filter that is partially translated is worse than one that is
+// not translated at all.
+package bpfc
+
+import (
+	"fmt"
+
+	"golang.org/x/net/bpf"
+
+	"github.com/netrack/openflow/ofp"
+)
+
+// Offsets, relative to the start of an untagged Ethernet II frame, of
+// the header fields the compiler recognizes.
+const (
+	offsetEtherType = 12
+	offsetIPProto   = 23
+	offsetIPHdrLen  = 14
+	offsetL4SrcPort = 14
+	offsetL4DstPort = 16
+)
+
+// EtherType values recognized in EtherType comparisons.
+const (
+	EtherTypeIPv4 = 0x0800
+	EtherTypeIPv6 = 0x86dd
+	EtherTypeARP  = 0x0806
+)
+
+// IP protocol numbers recognized in IP protocol comparisons.
+const (
+	IPProtoTCP = 6
+	IPProtoUDP = 17
+)
+
+// field identifies a packet header field the compiler can turn into a
+// match constraint.
+type field int
+
+const (
+	fieldEtherType field = iota
+	fieldIPProto
+	fieldL4SrcPort
+	fieldL4DstPort
+)
+
+// Match is the symbolic match state accumulated while walking a branch
+// of the BPF program. A nil pointer means the branch does not constrain
+// that field.
+//
+// Translating Match into an ofp.Match (i.e. into the OXM fields the
+// switch actually matches on) is intentionally left to the caller: this
+// package only ever observes a handful of fields, and the fuller OXM
+// vocabulary lives outside of its scope.
+type Match struct {
+	EtherType *uint16
+	IPProto   *uint8
+	L4SrcPort *uint16
+	L4DstPort *uint16
+}
+
+func (m Match) with(f field, v uint32) Match {
+	switch f {
+	case fieldEtherType:
+		val := uint16(v)
+		m.EtherType = &val
+	case fieldIPProto:
+		val := uint8(v)
+		m.IPProto = &val
+	case fieldL4SrcPort:
+		val := uint16(v)
+		m.L4SrcPort = &val
+	case fieldL4DstPort:
+		val := uint16(v)
+		m.L4DstPort = &val
+	}
+	return m
+}
+
+// Entry is a single flow-table entry produced by Compile: a packet
+// satisfying Match should be installed with Instructions.
+type Entry struct {
+	Match        Match
+	Instructions ofp.Instructions
+}
+
+// Option configures Compile.
+type Option func(*compiler)
+
+// WithOutputPort sets the port that an accepting branch (a non-zero
+// RetConstant) outputs to. The default is ofp.PortController, mirroring
+// the original filter's purpose of picking packets out for inspection.
+func WithOutputPort(port ofp.PortNo) Option {
+	return func(c *compiler) { c.outputPort = port }
+}
+
+// Compile translates prog into a list of flow entries.
+func Compile(prog []bpf.Instruction, opts ...Option) ([]Entry, error) {
+	c := &compiler{prog: prog, outputPort: ofp.PortController}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var entries []Entry
+	if err := c.walk(0, state{}, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+type compiler struct {
+	prog       []bpf.Instruction
+	outputPort ofp.PortNo
+}
+
+// state is the symbolic state carried along a single path through the
+// program: the match constraints collected so far, and, once a Load*
+// instruction has run, the field it loaded into the accumulator
+// register -- mirroring the BPF virtual machine's register A, which the
+// following Jump* instruction tests.
+type state struct {
+	match Match
+	cur   *field
+	ihl   bool // a LoadMemShift has computed the IPv4 header length into X
+}
+
+func (c *compiler) walk(pc int, st state, entries *[]Entry) error {
+	if pc < 0 || pc >= len(c.prog) {
+		return fmt.Errorf("bpfc: program counter %d out of range", pc)
+	}
+
+	switch ins := c.prog[pc].(type) {
+	case bpf.LoadAbsolute:
+		f, err := loadAbsoluteField(ins.Off, ins.Size)
+		if err != nil {
+			return err
+		}
+		st.cur = &f
+		return c.walk(pc+1, st, entries)
+
+	case bpf.LoadMemShift:
+		if ins.Off != offsetIPHdrLen {
+			return fmt.Errorf("bpfc: unsupported LoadMemShift offset %d", ins.Off)
+		}
+		st.ihl = true
+		return c.walk(pc+1, st, entries)
+
+	case bpf.LoadIndirect:
+		if !st.ihl {
+			return fmt.Errorf("bpfc: LoadIndirect at pc=%d is not preceded by a LoadMemShift", pc)
+		}
+		f, err := loadIndirectField(ins.Off, ins.Size)
+		if err != nil {
+			return err
+		}
+		st.cur = &f
+		return c.walk(pc+1, st, entries)
+
+	case bpf.JumpIf:
+		return c.walkJumpIf(pc, ins, st, entries)
+
+	case bpf.Jump:
+		return c.walk(pc+1+int(ins.Skip), st, entries)
+
+	case bpf.RetConstant:
+		*entries = append(*entries, c.entry(st.match, ins.Val))
+		return nil
+
+	default:
+		return fmt.Errorf("bpfc: unsupported BPF instruction %T at pc=%d", ins, pc)
+	}
+}
+
+func (c *compiler) walkJumpIf(pc int, ins bpf.JumpIf, st state, entries *[]Entry) error {
+	if st.cur == nil {
+		return fmt.Errorf("bpfc: JumpIf at pc=%d does not follow a Load instruction", pc)
+	}
+
+	switch ins.Cond {
+	case bpf.JumpEqual:
+		trueSt := st
+		trueSt.match = st.match.with(*st.cur, ins.Val)
+		if err := c.walk(pc+1+int(ins.SkipTrue), trueSt, entries); err != nil {
+			return err
+		}
+
+		// The false branch only knows the tested value was rejected,
+		// which has no positive OXM match representation, so it is
+		// walked without adding a constraint.
+		return c.walk(pc+1+int(ins.SkipFalse), st, entries)
+
+	default:
+		return fmt.Errorf("bpfc: unsupported BPF jump test %v at pc=%d", ins.Cond, pc)
+	}
+}
+
+func (c *compiler) entry(m Match, ret uint32) Entry {
+	if ret == 0 {
+		return Entry{Match: m, Instructions: ofp.Instructions{}}
+	}
+
+	return Entry{
+		Match: m,
+		Instructions: ofp.Instructions{
+			&ofp.InstructionApplyActions{
+				Actions: ofp.Actions{
+					&ofp.ActionOutput{Port: c.outputPort},
+				},
+			},
+		},
+	}
+}
+
+func loadAbsoluteField(off uint32, size int) (field, error) {
+	switch {
+	case off == offsetEtherType && size == 2:
+		return fieldEtherType, nil
+	case off == offsetIPProto && size == 1:
+		return fieldIPProto, nil
+	default:
+		return 0, fmt.Errorf("bpfc: unsupported LoadAbsolute at offset %d, size %d", off, size)
+	}
+}
+
+func loadIndirectField(off uint32, size int) (field, error) {
+	switch {
+	case off == offsetL4SrcPort && size == 2:
+		return fieldL4SrcPort, nil
+	case off == offsetL4DstPort && size == 2:
+		return fieldL4DstPort, nil
+	default:
+		return 0, fmt.Errorf("bpfc: unsupported LoadIndirect at offset %d, size %d", off, size)
+	}
+}
+
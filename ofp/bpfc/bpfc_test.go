@@ -0,0 +1,158 @@
+package bpfc
+
+import (
+	"testing"
+
+	"golang.org/x/net/bpf"
+
+	"github.com/netrack/openflow/ofp"
+)
+
+func u16(v uint16) *uint16 { return &v }
+func u8(v uint8) *uint8    { return &v }
+
+func TestCompileEtherType(t *testing.T) {
+	// ether proto ip
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: offsetEtherType, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: EtherTypeIPv4, SkipTrue: 0, SkipFalse: 1},
+		bpf.RetConstant{Val: 1},
+		bpf.RetConstant{Val: 0},
+	}
+
+	entries, err := Compile(prog)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	accept := entries[0]
+	if accept.Match.EtherType == nil || *accept.Match.EtherType != EtherTypeIPv4 {
+		t.Fatalf("accept entry Match.EtherType = %v, want %#x", accept.Match.EtherType, EtherTypeIPv4)
+	}
+	if len(accept.Instructions) != 1 {
+		t.Fatalf("accept entry has %d instructions, want 1", len(accept.Instructions))
+	}
+
+	drop := entries[1]
+	if drop.Match.EtherType != nil {
+		t.Fatalf("drop entry Match.EtherType = %v, want nil", drop.Match.EtherType)
+	}
+	if len(drop.Instructions) != 0 {
+		t.Fatalf("drop entry has %d instructions, want 0", len(drop.Instructions))
+	}
+}
+
+func TestCompileTCPDstPort(t *testing.T) {
+	// ip proto tcp and tcp dst port 80
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: offsetIPProto, Size: 1},            // pc0
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: IPProtoTCP, SkipTrue: 0, SkipFalse: 4}, // pc1 -> pc6 on false
+		bpf.LoadMemShift{Off: offsetIPHdrLen},                    // pc2
+		bpf.LoadIndirect{Off: offsetL4DstPort, Size: 2},          // pc3
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 80, SkipTrue: 0, SkipFalse: 1}, // pc4 -> pc6 on false
+		bpf.RetConstant{Val: 1}, // pc5
+		bpf.RetConstant{Val: 0}, // pc6
+	}
+
+	entries, err := Compile(prog)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	accept := entries[0]
+	if accept.Match.IPProto == nil || *accept.Match.IPProto != IPProtoTCP {
+		t.Fatalf("accept entry Match.IPProto = %v, want %d", accept.Match.IPProto, IPProtoTCP)
+	}
+	if accept.Match.L4DstPort == nil || *accept.Match.L4DstPort != 80 {
+		t.Fatalf("accept entry Match.L4DstPort = %v, want 80", accept.Match.L4DstPort)
+	}
+	if len(accept.Instructions) != 1 {
+		t.Fatalf("accept entry has %d instructions, want 1", len(accept.Instructions))
+	}
+
+	portMismatch := entries[1]
+	if portMismatch.Match.IPProto == nil || *portMismatch.Match.IPProto != IPProtoTCP {
+		t.Fatalf("port-mismatch entry Match.IPProto = %v, want %d", portMismatch.Match.IPProto, IPProtoTCP)
+	}
+	if portMismatch.Match.L4DstPort != nil {
+		t.Fatalf("port-mismatch entry Match.L4DstPort = %v, want nil", portMismatch.Match.L4DstPort)
+	}
+	if len(portMismatch.Instructions) != 0 {
+		t.Fatalf("port-mismatch entry has %d instructions, want 0", len(portMismatch.Instructions))
+	}
+
+	protoMismatch := entries[2]
+	if protoMismatch.Match.IPProto != nil {
+		t.Fatalf("proto-mismatch entry Match.IPProto = %v, want nil", protoMismatch.Match.IPProto)
+	}
+	if len(protoMismatch.Instructions) != 0 {
+		t.Fatalf("proto-mismatch entry has %d instructions, want 0", len(protoMismatch.Instructions))
+	}
+}
+
+func TestCompileWithOutputPort(t *testing.T) {
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: offsetEtherType, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: EtherTypeIPv4, SkipTrue: 0, SkipFalse: 1},
+		bpf.RetConstant{Val: 1},
+		bpf.RetConstant{Val: 0},
+	}
+
+	entries, err := Compile(prog, WithOutputPort(ofp.PortFlood))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	out, ok := entries[0].Instructions[0].(*ofp.InstructionApplyActions)
+	if !ok {
+		t.Fatalf("entries[0].Instructions[0] = %T, want *ofp.InstructionApplyActions", entries[0].Instructions[0])
+	}
+	action, ok := out.Actions[0].(*ofp.ActionOutput)
+	if !ok {
+		t.Fatalf("Actions[0] = %T, want *ofp.ActionOutput", out.Actions[0])
+	}
+	if action.Port != ofp.PortFlood {
+		t.Fatalf("ActionOutput.Port = %v, want PortFlood", action.Port)
+	}
+}
+
+func TestCompileRejectsJumpBitsSet(t *testing.T) {
+	// JSET tests "(A & mask) != 0", which has no OXM equality representation.
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: offsetIPProto, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x01, SkipTrue: 0, SkipFalse: 1},
+		bpf.RetConstant{Val: 1},
+		bpf.RetConstant{Val: 0},
+	}
+
+	if _, err := Compile(prog); err == nil {
+		t.Fatal("Compile with JumpBitsSet: got nil error, want error")
+	}
+}
+
+func TestCompileRejectsUnsupportedInstruction(t *testing.T) {
+	prog := []bpf.Instruction{
+		bpf.RetA{},
+	}
+
+	if _, err := Compile(prog); err == nil {
+		t.Fatal("Compile with RetA: got nil error, want error")
+	}
+}
+
+func TestCompileRejectsUnsupportedOffset(t *testing.T) {
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+		bpf.RetConstant{Val: 1},
+	}
+
+	if _, err := Compile(prog); err == nil {
+		t.Fatal("Compile with unsupported LoadAbsolute offset: got nil error, want error")
+	}
+}
@@ -2,9 +2,12 @@ package ofp
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 
+	opentracing "github.com/opentracing/opentracing-go"
+
 	"github.com/netrack/openflow/internal/encoding"
 )
 
@@ -34,6 +37,26 @@ const (
 // InstructionType represents a type of the flow modification instruction.
 type InstructionType uint16
 
+var instructionTypeNames = map[InstructionType]string{
+	InstructionTypeGotoTable:     "InstructionGotoTable",
+	InstructionTypeWriteMetadata: "InstructionWriteMetadata",
+	InstructionTypeWriteActions:  "InstructionWriteActions",
+	InstructionTypeApplyActions:  "InstructionApplyActions",
+	InstructionTypeClearActions:  "InstructionClearActions",
+	InstructionTypeMeter:         "InstructionMeter",
+	InstructionTypeExperimenter:  "InstructionExperimenter",
+}
+
+// String returns the name of the instruction type, e.g.
+// "InstructionApplyActions", for use in logs and tracing spans. Unknown
+// types are rendered as their hexadecimal value.
+func (t InstructionType) String() string {
+	if name, ok := instructionTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("InstructionType(0x%x)", uint16(t))
+}
+
 var instructionMap = map[InstructionType]encoding.ReaderMaker{
 	InstructionTypeGotoTable:     encoding.ReaderMakerOf(InstructionGotoTable{}),
 	InstructionTypeWriteMetadata: encoding.ReaderMakerOf(InstructionWriteMetadata{}),
@@ -81,6 +104,100 @@ func (i *Instructions) WriteTo(w io.Writer) (n int64, err error) {
 	return encoding.WriteTo(w, buf.Bytes())
 }
 
+type traceContextKey int
+
+const (
+	traceContextKeyDatapathID traceContextKey = iota
+	traceContextKeyTableID
+	traceContextKeyCookie
+)
+
+// ContextWithDatapathID attaches a datapath id to ctx, so that it is
+// set as a tag on the spans created while decoding instructions with
+// ReadFromContext.
+func ContextWithDatapathID(ctx context.Context, datapathID uint64) context.Context {
+	return context.WithValue(ctx, traceContextKeyDatapathID, datapathID)
+}
+
+// ContextWithTableID attaches a table id to ctx, so that it is set as a
+// tag on the spans created while decoding instructions with
+// ReadFromContext.
+func ContextWithTableID(ctx context.Context, table Table) context.Context {
+	return context.WithValue(ctx, traceContextKeyTableID, table)
+}
+
+// ContextWithCookie attaches a flow-mod cookie to ctx, so that it is set
+// as a tag on the spans created while decoding instructions with
+// ReadFromContext.
+func ContextWithCookie(ctx context.Context, cookie uint64) context.Context {
+	return context.WithValue(ctx, traceContextKeyCookie, cookie)
+}
+
+// ReadFromContext behaves like ReadFrom, but starts a child span for
+// every decoded instruction, named after its InstructionType (e.g.
+// "InstructionApplyActions"), as a child of any span found in ctx. When
+// present in ctx, the datapath id, table id and cookie set with
+// ContextWithDatapathID, ContextWithTableID and ContextWithCookie are
+// attached as span tags.
+func (i *Instructions) ReadFromContext(ctx context.Context, r io.Reader) (n int64, err error) {
+	var instType InstructionType
+
+	rm := func() (io.ReaderFrom, error) {
+		span, _ := opentracing.StartSpanFromContext(ctx, instType.String())
+
+		if datapathID, ok := ctx.Value(traceContextKeyDatapathID).(uint64); ok {
+			span.SetTag("openflow.datapath_id", datapathID)
+		}
+		if table, ok := ctx.Value(traceContextKeyTableID).(Table); ok {
+			span.SetTag("openflow.table_id", table)
+		}
+		if cookie, ok := ctx.Value(traceContextKeyCookie).(uint64); ok {
+			span.SetTag("openflow.cookie", cookie)
+		}
+
+		rm, ok := instructionMap[instType]
+		if !ok {
+			format := "ofp: unknown instruction type: '%x'"
+			err := fmt.Errorf(format, instType)
+			span.SetTag("error", true)
+			span.Finish()
+			return nil, err
+		}
+
+		rd, err := rm.MakeReader()
+		if err != nil {
+			span.SetTag("error", true)
+			span.Finish()
+			return nil, err
+		}
+
+		*i = append(*i, rd.(Instruction))
+		return tracedReader{ReaderFrom: rd, span: span}, nil
+	}
+
+	return encoding.ScanFrom(r, &instType,
+		encoding.ReaderMakerFunc(rm))
+}
+
+// tracedReader defers finishing the span started for a decoded
+// instruction until ReadFrom -- where the instruction's bytes are
+// actually consumed -- returns, so the span's duration reflects the
+// real decode time rather than just the type lookup that precedes it.
+type tracedReader struct {
+	io.ReaderFrom
+	span opentracing.Span
+}
+
+func (t tracedReader) ReadFrom(r io.Reader) (int64, error) {
+	defer t.span.Finish()
+
+	n, err := t.ReaderFrom.ReadFrom(r)
+	if err != nil {
+		t.span.SetTag("error", true)
+	}
+	return n, err
+}
+
 func (i *Instructions) ReadFrom(r io.Reader) (n int64, err error) {
 	var instType InstructionType
 
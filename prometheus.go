@@ -0,0 +1,131 @@
+package openflow
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusRunner decorates another Runner with Prometheus
+// instrumentation, so the saturation of the wrapped runner can be
+// scraped alongside the rest of an operator's metrics.
+//
+// It reports the number of queued and in-flight tasks, a histogram of
+// task execution latency, and a counter of tasks that were dropped
+// instead of being executed (for runners that support rejecting work,
+// such as MultiRoutineRunner configured with an overflow policy other
+// than Block).
+type PrometheusRunner struct {
+	runner Runner
+
+	queued   prometheus.Gauge
+	inFlight prometheus.Gauge
+	latency  prometheus.Histogram
+	dropped  prometheus.Counter
+}
+
+// NewPrometheusRunner wraps runner with Prometheus instrumentation and
+// registers the collectors with reg.
+//
+// The namespace and subsystem of the registered metrics are fixed to
+// "openflow" and "runner", so multiple PrometheusRunner instances
+// reporting to the same registry should be distinguished with
+// prometheus.WrapRegistererWith or a similar constant label wrapper.
+func NewPrometheusRunner(runner Runner, reg prometheus.Registerer) *PrometheusRunner {
+	pr := &PrometheusRunner{
+		runner: runner,
+		queued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "openflow",
+			Subsystem: "runner",
+			Name:      "queued_tasks",
+			Help:      "Number of tasks waiting to be run.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "openflow",
+			Subsystem: "runner",
+			Name:      "in_flight_tasks",
+			Help:      "Number of tasks currently executing.",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "openflow",
+			Subsystem: "runner",
+			Name:      "task_duration_seconds",
+			Help:      "Histogram of task execution time in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "openflow",
+			Subsystem: "runner",
+			Name:      "dropped_tasks_total",
+			Help:      "Total number of tasks dropped instead of executed.",
+		}),
+	}
+
+	reg.MustRegister(pr.queued, pr.inFlight, pr.latency, pr.dropped)
+	return pr
+}
+
+// instrumented wraps fn so that it is accounted as queued until it
+// starts, then as in-flight until it completes, recording its execution
+// latency.
+func (pr *PrometheusRunner) instrumented(fn func()) func() {
+	return func() {
+		pr.queued.Dec()
+		pr.inFlight.Inc()
+		defer pr.inFlight.Dec()
+
+		start := time.Now()
+		defer func() { pr.latency.Observe(time.Since(start).Seconds()) }()
+
+		fn()
+	}
+}
+
+// dropCounter is implemented by runners that track how many tasks they
+// have dropped under their overflow policy, such as MultiRoutineRunner.
+type dropCounter interface {
+	DroppedTasks() uint64
+}
+
+// Run implements the Runner interface, always calling Run on the
+// wrapped runner so its blocking contract (e.g. MultiRoutineRunner's
+// Block policy) is preserved. When the wrapped runner implements
+// dropCounter, Run compares its drop count before and after the call to
+// tell whether fn was silently dropped instead of executed, and
+// accounts for that instead of leaking the queued gauge forever.
+func (pr *PrometheusRunner) Run(fn func()) {
+	pr.queued.Inc()
+
+	dc, counts := pr.runner.(dropCounter)
+	var before uint64
+	if counts {
+		before = dc.DroppedTasks()
+	}
+
+	pr.runner.Run(pr.instrumented(fn))
+
+	if counts && dc.DroppedTasks() != before {
+		pr.queued.Dec()
+		pr.dropped.Inc()
+	}
+}
+
+// TryRun attempts to run fn on the wrapped runner, provided it
+// implements a TryRun(func()) error method (see MultiRoutineRunner),
+// and records a dropped task whenever the runner rejects the work.
+func (pr *PrometheusRunner) TryRun(fn func()) error {
+	tr, ok := pr.runner.(interface{ TryRun(func()) error })
+	if !ok {
+		pr.Run(fn)
+		return nil
+	}
+
+	pr.queued.Inc()
+	err := tr.TryRun(pr.instrumented(fn))
+	if err != nil {
+		pr.queued.Dec()
+		pr.dropped.Inc()
+	}
+
+	return err
+}
@@ -1,7 +1,16 @@
 package openflow
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Runner describes types used to start a function according to the
@@ -10,6 +19,15 @@ type Runner interface {
 	Run(func())
 }
 
+// ContextRunner is an optional extension of Runner for implementations
+// that can carry a context.Context into the goroutine that executes the
+// task. Implementing it allows request-scoped values, such as tracing
+// spans started when a PacketIn arrives, to survive the hop through the
+// runner's scheduling instead of being erased by Run(func()).
+type ContextRunner interface {
+	RunContext(ctx context.Context, fn func(context.Context))
+}
+
 // OnDemandRoutineRunner is a runner that starts each function in a
 // separate goroutine. This handler is useful for initial prototyping,
 // but it is highly recommended to use runner with a fixed amount of
@@ -22,6 +40,12 @@ func (_ OnDemandRoutineRunner) Run(fn func()) {
 	go fn()
 }
 
+// RunContext starts a function in a separate go-routine, passing it ctx.
+// This method implements ContextRunner interface.
+func (_ OnDemandRoutineRunner) RunContext(ctx context.Context, fn func(context.Context)) {
+	go fn(ctx)
+}
+
 // SequentialRunner is a runner that starts each function one by one.
 // New function does not start execution until the previous one is done.
 //
@@ -33,35 +57,366 @@ func (_ SequentialRunner) Run(fn func()) {
 	fn()
 }
 
+// RunContext runs a function as is, passing it ctx. This method
+// implements ContextRunner interface.
+func (_ SequentialRunner) RunContext(ctx context.Context, fn func(context.Context)) {
+	fn(ctx)
+}
+
+// OverflowPolicy controls how a MultiRoutineRunner behaves when its work
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// Block waits until there is room in the queue. This is the
+	// default, and matches the original MultiRoutineRunner behavior.
+	Block OverflowPolicy = iota
+
+	// DropNewest discards the incoming task, keeping the tasks that are
+	// already queued.
+	DropNewest
+
+	// DropOldest discards the oldest queued task to make room for the
+	// incoming one.
+	DropOldest
+
+	// Reject makes TryRun return ErrQueueFull instead of queueing the
+	// task. Run, which has no way to report an error, treats Reject the
+	// same way as DropNewest.
+	Reject
+)
+
+// ErrQueueFull is returned by MultiRoutineRunner.TryRun when the work
+// queue is full and the runner's overflow policy is Reject.
+var ErrQueueFull = errors.New("openflow: runner queue is full")
+
+// ActiveTask describes a task while it is executing. It is returned by
+// MultiRoutineRunner.ActiveTasks and written to the active task log
+// enabled by WithActiveTaskLog.
+type ActiveTask struct {
+	ID        uint64    `json:"id"`
+	Label     string    `json:"label"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// MultiRoutineRunnerOption configures a MultiRoutineRunner created by
+// NewMultiRoutineRunner.
+type MultiRoutineRunnerOption func(*MultiRoutineRunner)
+
+// WithOverflowPolicy sets the policy applied when the work queue is
+// full. The default is Block.
+func WithOverflowPolicy(policy OverflowPolicy) MultiRoutineRunnerOption {
+	return func(mrr *MultiRoutineRunner) { mrr.policy = policy }
+}
+
+// WithQueueLen sets the capacity of the work queue independently of the
+// number of worker goroutines. The default equals the number of
+// routines passed to NewMultiRoutineRunner.
+func WithQueueLen(n int) MultiRoutineRunnerOption {
+	return func(mrr *MultiRoutineRunner) { mrr.queueLen = n }
+}
+
+// WithTaskTimeout arranges for a warning to be logged whenever a single
+// task runs longer than d. MultiRoutineRunner has no way to preempt a
+// running function, so the task itself keeps running; the timeout only
+// surfaces that it is stuck.
+func WithTaskTimeout(d time.Duration) MultiRoutineRunnerOption {
+	return func(mrr *MultiRoutineRunner) { mrr.taskTimeout = d }
+}
+
+// WithActiveTaskLog periodically writes the set of currently-running
+// tasks to w as newline-delimited JSON. Pass an *os.File to persist the
+// log to disk.
+func WithActiveTaskLog(w io.Writer, interval time.Duration) MultiRoutineRunnerOption {
+	return func(mrr *MultiRoutineRunner) {
+		mrr.activeLog = w
+		mrr.activeLogInterval = interval
+	}
+}
+
+// MultiRoutineRunner is a runner that executes tasks on a fixed pool of
+// worker goroutines, queueing work that arrives while all workers are
+// busy.
 type MultiRoutineRunner struct {
-	num  int
-	q    chan func()
+	num      int
+	queueLen int
+	policy   OverflowPolicy
+
+	taskTimeout       time.Duration
+	activeLog         io.Writer
+	activeLogInterval time.Duration
+
+	q    chan task
 	once sync.Once
+	wg   sync.WaitGroup
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeMu   sync.Mutex // guards isClosed and sendWG against a concurrent Close
+	isClosed  bool
+	sendWG    sync.WaitGroup // tracks enqueue calls in flight, so Close knows when it is safe to close q
+
+	qMu sync.Mutex // serializes the whole enqueue decision for the DropOldest policy
+
+	dropped uint64 // atomic: tasks dropped by the overflow policy, see DroppedTasks
+
+	mu     sync.Mutex
+	active map[uint64]ActiveTask
+	nextID uint64
+}
+
+type task struct {
+	fn    func()
+	label string
 }
 
-func NewMultiRoutineRunner(num int) *MultiRoutineRunner {
+// NewMultiRoutineRunner creates a runner backed by num worker goroutines.
+// By default the work queue blocks callers once it holds num tasks; use
+// WithQueueLen, WithOverflowPolicy and the other options to change that.
+func NewMultiRoutineRunner(num int, opts ...MultiRoutineRunnerOption) *MultiRoutineRunner {
 	if num <= 0 {
 		panic("number of routines must be positive")
 	}
-	return &MultiRoutineRunner{
-		num: num,
-		q:   make(chan func(), num),
+
+	mrr := &MultiRoutineRunner{
+		num:      num,
+		queueLen: num,
+		policy:   Block,
+		closed:   make(chan struct{}),
+		active:   make(map[uint64]ActiveTask),
+	}
+
+	for _, opt := range opts {
+		opt(mrr)
 	}
+
+	mrr.q = make(chan task, mrr.queueLen)
+	return mrr
 }
 
 func (mrr *MultiRoutineRunner) init() {
 	for i := 0; i < mrr.num; i++ {
+		mrr.wg.Add(1)
 		go mrr.runner()
 	}
+	if mrr.activeLog != nil {
+		go mrr.logActiveTasks()
+	}
 }
 
 func (mrr *MultiRoutineRunner) runner() {
-	for fn := range mrr.q {
-		fn()
+	defer mrr.wg.Done()
+	for t := range mrr.q {
+		mrr.execute(t)
+	}
+}
+
+func (mrr *MultiRoutineRunner) execute(t task) {
+	id := mrr.beginTask(t.label)
+	defer mrr.endTask(id)
+
+	if mrr.taskTimeout > 0 {
+		timer := time.AfterFunc(mrr.taskTimeout, func() {
+			log.Printf("openflow: task %q (id=%d) is still running after %s",
+				t.label, id, mrr.taskTimeout)
+		})
+		defer timer.Stop()
+	}
+
+	t.fn()
+}
+
+func (mrr *MultiRoutineRunner) beginTask(label string) uint64 {
+	mrr.mu.Lock()
+	defer mrr.mu.Unlock()
+
+	id := mrr.nextID
+	mrr.nextID++
+	mrr.active[id] = ActiveTask{ID: id, Label: label, StartedAt: time.Now()}
+	return id
+}
+
+func (mrr *MultiRoutineRunner) endTask(id uint64) {
+	mrr.mu.Lock()
+	delete(mrr.active, id)
+	mrr.mu.Unlock()
+}
+
+// ActiveTasks returns a snapshot of the tasks currently executing. It is
+// safe to call concurrently with Run and TryRun.
+func (mrr *MultiRoutineRunner) ActiveTasks() []ActiveTask {
+	mrr.mu.Lock()
+	defer mrr.mu.Unlock()
+
+	tasks := make([]ActiveTask, 0, len(mrr.active))
+	for _, t := range mrr.active {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// DroppedTasks returns the number of tasks dropped so far under the
+// configured overflow policy, either because they could not be enqueued
+// or, for DropOldest, because a later task evicted them first.
+func (mrr *MultiRoutineRunner) DroppedTasks() uint64 {
+	return atomic.LoadUint64(&mrr.dropped)
+}
+
+func (mrr *MultiRoutineRunner) logActiveTasks() {
+	ticker := time.NewTicker(mrr.activeLogInterval)
+	defer ticker.Stop()
+
+	enc := json.NewEncoder(mrr.activeLog)
+	for {
+		select {
+		case <-ticker.C:
+			for _, t := range mrr.ActiveTasks() {
+				// Best effort: a write error here must not bring down
+				// the runner, so it is only available through the
+				// active task log's own io.Writer failures.
+				enc.Encode(t)
+			}
+		case <-mrr.closed:
+			return
+		}
 	}
 }
 
+func taskLabel(fn func()) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
+
+// Run enqueues fn for execution by one of the worker goroutines. When
+// the queue is full, its behavior depends on the overflow policy: it
+// blocks for Block, evicts the oldest queued task for DropOldest, or
+// silently drops fn for DropNewest and Reject.
 func (mrr *MultiRoutineRunner) Run(fn func()) {
 	mrr.once.Do(mrr.init)
-	mrr.q <- fn
+	mrr.enqueue(fn, true)
+}
+
+// TryRun makes a single non-blocking attempt to enqueue fn, returning
+// ErrQueueFull instead of waiting or dropping fn silently whenever that
+// attempt does not succeed -- which, depending on the overflow policy,
+// can happen even though Run would have queued the same fn (Block
+// blocks instead, and DropOldest evicts an older task to make room).
+func (mrr *MultiRoutineRunner) TryRun(fn func()) error {
+	mrr.once.Do(mrr.init)
+	if !mrr.enqueue(fn, false) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// enqueue registers itself with sendWG before touching q, and Close
+// waits on sendWG before closing q, so a send here can never race the
+// close of q: either enqueue observes isClosed and bails out before
+// Close proceeds, or Close's close(q) waits until this call (already
+// counted in sendWG) has returned.
+func (mrr *MultiRoutineRunner) enqueue(fn func(), allowBlock bool) (sent bool) {
+	mrr.closeMu.Lock()
+	if mrr.isClosed {
+		mrr.closeMu.Unlock()
+		return false
+	}
+	mrr.sendWG.Add(1)
+	mrr.closeMu.Unlock()
+	defer mrr.sendWG.Done()
+
+	defer func() {
+		if !sent {
+			atomic.AddUint64(&mrr.dropped, 1)
+		}
+	}()
+
+	t := task{fn: fn, label: taskLabel(fn)}
+
+	if mrr.policy == DropOldest {
+		// The fast path and, if that fails, the evict-then-insert retry
+		// must run as a single step per caller: otherwise a concurrent
+		// caller's own fast-path send can steal the slot this goroutine
+		// just freed, dropping both tasks instead of swapping old for
+		// new.
+		mrr.qMu.Lock()
+		defer mrr.qMu.Unlock()
+
+		select {
+		case mrr.q <- t:
+			return true
+		default:
+		}
+
+		// TryRun does not evict: it only reports that the queue is full.
+		if !allowBlock {
+			return false
+		}
+
+		select {
+		case <-mrr.q:
+			// The evicted task is never going to run; count it as
+			// dropped even though this call's own fn (t) still lands.
+			atomic.AddUint64(&mrr.dropped, 1)
+		default:
+		}
+		select {
+		case mrr.q <- t:
+			return true
+		default:
+			return false
+		}
+	}
+
+	select {
+	case mrr.q <- t:
+		return true
+	default:
+	}
+
+	switch mrr.policy {
+	case Block:
+		if !allowBlock {
+			return false
+		}
+		select {
+		case mrr.q <- t:
+			return true
+		case <-mrr.closed:
+			return false
+		}
+
+	default: // DropNewest, Reject
+		return false
+	}
+}
+
+// Close stops accepting new work and waits for the queue to drain and
+// all in-flight tasks to finish before returning.
+func (mrr *MultiRoutineRunner) Close() error {
+	mrr.once.Do(mrr.init)
+	mrr.closeOnce.Do(func() {
+		mrr.closeMu.Lock()
+		mrr.isClosed = true
+		mrr.closeMu.Unlock()
+
+		// Unblock any enqueue call parked in the Block policy's select,
+		// then wait for every in-flight enqueue call to return before
+		// closing q, so close(q) can never race a send on it.
+		close(mrr.closed)
+		mrr.sendWG.Wait()
+		close(mrr.q)
+	})
+	mrr.wg.Wait()
+	return nil
+}
+
+// RunContext enqueues a function together with ctx, so the worker
+// goroutine that eventually executes it can observe values (such as a
+// tracing span) attached to ctx. This method implements ContextRunner
+// interface. Like Run, it is subject to the configured overflow policy,
+// and a dropped fn is not reported to the caller.
+func (mrr *MultiRoutineRunner) RunContext(ctx context.Context, fn func(context.Context)) {
+	mrr.Run(func() { fn(ctx) })
 }
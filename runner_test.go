@@ -0,0 +1,81 @@
+package openflow
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMultiRoutineRunnerOverflowPolicies exercises Run and TryRun against
+// a single-slot queue under contention, for every OverflowPolicy. It is
+// meant to be run with -race: the policies share the enqueue fast path,
+// and DropOldest additionally evicts a queued task, both of which have
+// previously raced against concurrent callers and against Close.
+func TestMultiRoutineRunnerOverflowPolicies(t *testing.T) {
+	const submitted = 64
+
+	for _, policy := range []OverflowPolicy{Block, DropNewest, DropOldest, Reject} {
+		policy := policy
+
+		t.Run(policy.String(), func(t *testing.T) {
+			mrr := NewMultiRoutineRunner(1, WithQueueLen(1), WithOverflowPolicy(policy))
+
+			release := make(chan struct{})
+			running := make(chan struct{})
+			mrr.Run(func() { close(running); <-release }) // occupies the single worker
+			<-running
+
+			var executed int64
+			var started, done sync.WaitGroup
+			started.Add(submitted)
+			done.Add(submitted)
+
+			for i := 0; i < submitted; i++ {
+				go func() {
+					defer done.Done()
+					started.Done()
+
+					fn := func() { atomic.AddInt64(&executed, 1) }
+					if policy == Reject {
+						mrr.TryRun(fn)
+						return
+					}
+					mrr.Run(fn)
+				}()
+			}
+
+			started.Wait()
+			close(release)
+			done.Wait()
+
+			if err := mrr.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			if got, want := atomic.LoadInt64(&executed)+int64(mrr.DroppedTasks()), int64(submitted); got != want {
+				t.Fatalf("executed(%d) + DroppedTasks(%d) = %d, want %d",
+					executed, mrr.DroppedTasks(), got, want)
+			}
+
+			if policy == Block && mrr.DroppedTasks() != 0 {
+				t.Fatalf("Block policy dropped %d tasks, want 0", mrr.DroppedTasks())
+			}
+		})
+	}
+}
+
+// String renders an OverflowPolicy for use as a test name.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "Block"
+	case DropNewest:
+		return "DropNewest"
+	case DropOldest:
+		return "DropOldest"
+	case Reject:
+		return "Reject"
+	default:
+		return "unknown"
+	}
+}